@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strconv"
+
+	"google.golang.org/proto/protogen"
+	"google.golang.org/proto/protogen/gengo"
+)
+
+const grpcPackage = "google.golang.org/grpc"
+
+// grpcPlugin implements rpcPlugin for github.com/grpc/grpc-go, selected via
+// --go_opt=plugins=grpc.
+type grpcPlugin struct{}
+
+func (grpcPlugin) GenService(g *protogen.GeneratedFile, f *File, service *protogen.Service) {
+	clientName := service.GoName + "Client"
+	clientImpl := unexport(clientName)
+
+	g.P("func New", clientName, "(cc ", grpcIdent("ClientConnInterface"), ") ", clientName, " {")
+	g.P("return &", clientImpl, "{cc}")
+	g.P("}")
+	g.P()
+	g.P("type ", clientImpl, " struct {")
+	g.P("cc ", grpcIdent("ClientConnInterface"))
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func (c *", clientImpl, ") ", method.GoName, "(ctx ", gengo.ContextIdent, ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error) {")
+		if isStreaming(method) {
+			g.P("// TODO: stream wrappers for client/server-streaming methods; ", method.GoName, " is a streaming RPC and cannot be served by a unary Invoke.")
+			g.P("return nil, ", fmtIdent("Errorf"), `("`, method.GoName, ` is a streaming method, not yet supported by plugins=grpc")`)
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("out := new(", method.Output.GoIdent, ")")
+		g.P("err := c.cc.Invoke(ctx, ", strconv.Quote(fullMethod(service, method)), ", in, out)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			continue
+		}
+		g.P("func ", handlerName(service, method), "(srv interface{}, ctx ", gengo.ContextIdent, ", dec func(interface{}) error, interceptor ", grpcIdent("UnaryServerInterceptor"), ") (interface{}, error) {")
+		g.P("in := new(", method.Input.GoIdent, ")")
+		g.P("if err := dec(in); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if interceptor == nil {")
+		g.P("return srv.(", service.GoName, "Server).", method.GoName, "(ctx, in)")
+		g.P("}")
+		g.P("info := &", grpcIdent("UnaryServerInfo"), "{")
+		g.P("Server:     srv,")
+		g.P("FullMethod: ", strconv.Quote(fullMethod(service, method)), ",")
+		g.P("}")
+		g.P("handler := func(ctx ", gengo.ContextIdent, ", req interface{}) (interface{}, error) {")
+		g.P("return srv.(", service.GoName, "Server).", method.GoName, "(ctx, req.(*", method.Input.GoIdent, "))")
+		g.P("}")
+		g.P("return interceptor(ctx, in, info, handler)")
+		g.P("}")
+		g.P()
+	}
+
+	serviceDesc := "_" + service.GoName + "_serviceDesc"
+	g.P("func Register", service.GoName, "Server(s ", grpcIdent("ServiceRegistrar"), ", srv ", service.GoName, "Server) {")
+	g.P("s.RegisterService(&", serviceDesc, ", srv)")
+	g.P("}")
+	g.P()
+
+	g.P("var ", serviceDesc, " = ", grpcIdent("ServiceDesc"), "{")
+	g.P("ServiceName: ", strconv.Quote(string(service.Desc.FullName())), ",")
+	g.P("HandlerType: (*", service.GoName, "Server)(nil),")
+	g.P("Methods: []", grpcIdent("MethodDesc"), "{")
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			continue
+		}
+		g.P("{")
+		g.P("MethodName: ", strconv.Quote(string(method.Desc.Name())), ",")
+		g.P("Handler:    ", handlerName(service, method), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Streams: []", grpcIdent("StreamDesc"), "{},")
+	g.P("Metadata: ", strconv.Quote(f.Desc.Path()), ",")
+	g.P("}")
+	g.P()
+}
+
+func isStreaming(method *protogen.Method) bool {
+	return method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()
+}
+
+func handlerName(service *protogen.Service, method *protogen.Method) string {
+	return "_" + service.GoName + "_" + method.GoName + "_Handler"
+}
+
+func grpcIdent(name string) protogen.GoIdent {
+	return protogen.GoIdent{GoImportPath: grpcPackage, GoName: name}
+}
+
+func fmtIdent(name string) protogen.GoIdent {
+	return protogen.GoIdent{GoImportPath: "fmt", GoName: name}
+}
+
+func fullMethod(service *protogen.Service, method *protogen.Method) string {
+	return "/" + string(service.Desc.FullName()) + "/" + string(method.Desc.Name())
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strconv"
+
+	"google.golang.org/proto/protogen"
+	"google.golang.org/proto/protogen/gengo"
+)
+
+const microPackage = "github.com/micro/go-micro/v2/server"
+const microClientPackage = "github.com/micro/go-micro/v2/client"
+
+// microPlugin implements rpcPlugin for go-micro's request/response/stream
+// model, selected via --go_opt=plugins=micro.
+type microPlugin struct{}
+
+func (microPlugin) GenService(g *protogen.GeneratedFile, f *File, service *protogen.Service) {
+	clientName := service.GoName + "Client"
+	clientImpl := unexport(clientName)
+
+	g.P("func New", clientName, "(name string, c ", microIdent(microClientPackage, "Client"), ") ", clientName, " {")
+	g.P("return &", clientImpl, "{c, name}")
+	g.P("}")
+	g.P()
+	g.P("type ", clientImpl, " struct {")
+	g.P("c    ", microIdent(microClientPackage, "Client"))
+	g.P("name string")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func (c *", clientImpl, ") ", method.GoName, "(ctx ", gengo.ContextIdent, ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error) {")
+		if isStreaming(method) {
+			g.P("// TODO: request/response/stream wrappers for go-micro's codec; ", method.GoName, " is a streaming RPC and cannot be served by a unary Call.")
+			g.P("return nil, ", fmtIdent("Errorf"), `("`, method.GoName, ` is a streaming method, not yet supported by plugins=micro")`)
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("req := c.c.NewRequest(c.name, ", strconv.Quote(string(service.Desc.Name())+"."+string(method.Desc.Name())), ", in)")
+		g.P("out := new(", method.Output.GoIdent, ")")
+		g.P("err := c.c.Call(ctx, req, out)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func Register", service.GoName, "Server(s ", microIdent(microPackage, "Server"), ", srv ", service.GoName, "Server) error {")
+	g.P("return s.Handle(s.NewHandler(srv))")
+	g.P("}")
+	g.P()
+}
+
+func microIdent(pkg, name string) protogen.GoIdent {
+	return protogen.GoIdent{GoImportPath: pkg, GoName: name}
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"google.golang.org/proto/protogen"
+	"google.golang.org/proto/protogen/gengo"
+)
+
+const twirpPackage = "github.com/twitchtv/twirp"
+
+// twirpPlugin implements rpcPlugin for github.com/twitchtv/twirp, selected
+// via --go_opt=plugins=twirp.
+type twirpPlugin struct{}
+
+func (twirpPlugin) GenService(g *protogen.GeneratedFile, f *File, service *protogen.Service) {
+	clientName := service.GoName + "Client"
+	clientImpl := unexport(clientName)
+
+	g.P("func New", clientName, "JSONClient(baseURL string, client ", httpClientIdent, ") ", clientName, " {")
+	g.P("return &", clientImpl, "{baseURL, client}")
+	g.P("}")
+	g.P()
+	g.P("type ", clientImpl, " struct {")
+	g.P("baseURL string")
+	g.P("client  ", httpClientIdent)
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func (c *", clientImpl, ") ", method.GoName, "(ctx ", gengo.ContextIdent, ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error) {")
+		if isStreaming(method) {
+			g.P("// twirp is a unary-only protocol; ", method.GoName, " is a streaming RPC and has no wire representation here.")
+			g.P("return nil, ", fmtIdent("Errorf"), `("`, method.GoName, ` is a streaming method, not supported by plugins=twirp")`)
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("out := new(", method.Output.GoIdent, ")")
+		g.P("// TODO: JSON/protobuf request marshaling per the twirp wire protocol.")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func Register", service.GoName, "Server(srv ", service.GoName, "Server, opts ...", twirpIdent("ServerOption"), ") ", twirpIdent("TwirpServer"), " {")
+	g.P("// TODO: PathPrefix and handler wiring.")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+}
+
+var httpClientIdent = protogen.GoIdent{GoImportPath: "net/http", GoName: "Client"}
+
+func twirpIdent(name string) protogen.GoIdent {
+	return protogen.GoIdent{GoImportPath: twirpPackage, GoName: name}
+}
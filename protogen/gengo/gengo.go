@@ -0,0 +1,357 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gengo provides the File/descriptor infrastructure shared by
+// protoc-gen-go and downstream protoc plugins. It exports the File-walking,
+// comment-extraction, and gzipped-FileDescriptorProto embedding that would
+// otherwise have to be re-derived by every generator that wants to emit
+// additional Go code alongside (or appended to) protoc-gen-go's own output.
+package gengo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/proto/protogen"
+	"google.golang.org/proto/reflect/protoreflect"
+)
+
+// ProtoPackage is the import path of the proto package used by generated
+// code, e.g. for its EnumName and RegisterEnum helpers.
+const ProtoPackage = "github.com/golang/protobuf/proto"
+
+// contextPackage is the import path of the context type referenced by
+// generated client and server method signatures.
+const contextPackage = "context"
+
+// ContextIdent is the context.Context type referenced by generated client
+// and server method signatures, exported so that rpcPlugin implementations
+// outside this package can use the same identifier.
+var ContextIdent = protogen.GoIdent{GoImportPath: contextPackage, GoName: "Context"}
+
+// Hooks, if set on a File, are invoked after the built-in generation for
+// each enum, message, or service in that file, letting an external
+// generator append additional Go code into the same output (or a sibling
+// file) without re-deriving the File's descriptor var or re-parsing its
+// SourceCodeInfo.
+type Hooks struct {
+	Enum    func(g *protogen.GeneratedFile, f *File, enum *protogen.Enum)
+	Message func(g *protogen.GeneratedFile, f *File, message *protogen.Message)
+	Service func(g *protogen.GeneratedFile, f *File, service *protogen.Service)
+}
+
+// File wraps a protogen.File with the extra state needed to generate a
+// .pb.go file.
+type File struct {
+	*protogen.File
+
+	// LocationMap indexes the file's SourceCodeInfo by path, as produced by
+	// PathKey, so that GenComment can look up the leading comment for any
+	// element without re-scanning SourceCodeInfo.
+	LocationMap map[string][]*descpb.SourceCodeInfo_Location
+
+	// DescriptorVar is the name of the package-level var that will hold the
+	// gzipped FileDescriptorProto, e.g. "fileDescriptor_0123456789abcdef".
+	DescriptorVar string
+
+	// Init accumulates the statements to be emitted inside the generated
+	// file's func init(), such as proto.RegisterEnum calls. Append to it
+	// with AppendInit.
+	Init []string
+
+	// Hooks, if non-nil fields, are called after the built-in generation
+	// for the corresponding element.
+	Hooks Hooks
+}
+
+// NewFile derives a *File from a protogen.File: it parses the file's
+// SourceCodeInfo into a LocationMap and computes the name of the var that
+// will hold its gzipped FileDescriptorProto.
+func NewFile(file *protogen.File) *File {
+	f := &File{
+		File:        file,
+		LocationMap: make(map[string][]*descpb.SourceCodeInfo_Location),
+	}
+	for _, loc := range file.Proto.GetSourceCodeInfo().GetLocation() {
+		key := PathKey(loc.Path)
+		f.LocationMap[key] = append(f.LocationMap[key], loc)
+	}
+
+	// Determine the name of the var holding the file descriptor:
+	//
+	//     fileDescriptor_<hash of filename>
+	filenameHash := sha256.Sum256([]byte(f.Desc.Path()))
+	f.DescriptorVar = fmt.Sprintf("fileDescriptor_%s", hex.EncodeToString(filenameHash[:8]))
+	return f
+}
+
+// AppendInit appends a statement to be emitted inside the generated file's
+// func init(), e.g. a proto.RegisterEnum or proto.RegisterType call.
+func (f *File) AppendInit(stmt string) {
+	f.Init = append(f.Init, stmt)
+}
+
+// GenFile generates the .pb.go file for file: the package clause, one
+// declaration per enum/message/service, the func init() block, and the
+// gzipped FileDescriptorProto. hooks, if set, are invoked after the
+// built-in generation for the corresponding enum, message, or service,
+// letting a caller append additional Go code into the same output. This is
+// the entry point downstream generators should call; protoc-gen-go itself
+// is a thin wrapper around it.
+func GenFile(gen *protogen.Plugin, file *protogen.File, hooks Hooks) *File {
+	f := NewFile(file)
+	f.Hooks = hooks
+
+	g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".pb.go", f.GoImportPath)
+	g.P("// Code generated by protoc-gen-go. DO NOT EDIT.")
+	g.P("// source: ", f.Desc.Path())
+	g.P()
+	const filePackageField = 2 // FileDescriptorProto.package
+	GenComment(g, f, []int32{filePackageField})
+	g.P()
+	g.P("package ", f.GoPackageName)
+	g.P()
+
+	for _, enum := range f.Enums {
+		genEnum(g, f, enum)
+	}
+	for _, message := range f.Messages {
+		genMessage(g, f, message)
+	}
+	for _, service := range f.Services {
+		genService(g, f, service)
+	}
+
+	GenInit(g, f)
+	GenFileDescriptor(gen, g, f)
+	return f
+}
+
+// GenComment writes the leading comment (if any) found at path as a
+// sequence of "//"-prefixed lines.
+func GenComment(g *protogen.GeneratedFile, f *File, path []int32) {
+	for _, loc := range f.LocationMap[PathKey(path)] {
+		if loc.LeadingComments == nil {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(loc.GetLeadingComments(), "\n"), "\n") {
+			g.P("//", line)
+		}
+		return
+	}
+}
+
+// PathKey converts a SourceCodeInfo path to a string suitable for use as a
+// map key.
+func PathKey(path []int32) string {
+	var buf []byte
+	for i, x := range path {
+		if i != 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendInt(buf, int64(x), 10)
+	}
+	return string(buf)
+}
+
+// GenInit emits the func init() block accumulated in f.Init, if non-empty.
+func GenInit(g *protogen.GeneratedFile, f *File) {
+	if len(f.Init) == 0 {
+		return
+	}
+	g.P("func init() {")
+	for _, s := range f.Init {
+		g.P(s)
+	}
+	g.P("}")
+	g.P()
+}
+
+// GenFileDescriptor marshals f's FileDescriptorProto (with SourceCodeInfo
+// trimmed), gzips it, and writes it as the byte slice named by
+// f.DescriptorVar, along with the proto.RegisterFile call that registers it.
+func GenFileDescriptor(gen *protogen.Plugin, g *protogen.GeneratedFile, f *File) {
+	// Trim the source_code_info from the descriptor.
+	// Marshal and gzip it.
+	descProto := proto.Clone(f.Proto).(*descpb.FileDescriptorProto)
+	descProto.SourceCodeInfo = nil
+	b, err := proto.Marshal(descProto)
+	if err != nil {
+		gen.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w.Write(b)
+	w.Close()
+	b = buf.Bytes()
+
+	g.P("func init() { proto.RegisterFile(", strconv.Quote(f.Desc.Path()), ", ", f.DescriptorVar, ") }")
+	g.P()
+	g.P("var ", f.DescriptorVar, " = []byte{")
+	g.P("// ", len(b), " bytes of a gzipped FileDescriptorProto")
+	for len(b) > 0 {
+		n := 16
+		if n > len(b) {
+			n = len(b)
+		}
+
+		s := ""
+		for _, c := range b[:n] {
+			s += fmt.Sprintf("0x%02x,", c)
+		}
+		g.P(s)
+
+		b = b[n:]
+	}
+	g.P("}")
+	g.P()
+}
+
+func genEnum(g *protogen.GeneratedFile, f *File, enum *protogen.Enum) {
+	GenComment(g, f, enum.Path)
+	// TODO: deprecation
+	g.P("type ", enum.GoIdent, " int32")
+	g.P("const (")
+	for _, value := range enum.Values {
+		GenComment(g, f, value.Path)
+		// TODO: deprecation
+		g.P(value.GoIdent, " ", enum.GoIdent, " = ", value.Desc.Number())
+	}
+	g.P(")")
+	g.P()
+	nameMap := enum.GoIdent.GoName + "_name"
+	g.P("var ", nameMap, " = map[int32]string{")
+	generated := make(map[protoreflect.EnumNumber]bool)
+	for _, value := range enum.Values {
+		duplicate := ""
+		if _, present := generated[value.Desc.Number()]; present {
+			duplicate = "// Duplicate value: "
+		}
+		g.P(duplicate, value.Desc.Number(), ": ", strconv.Quote(string(value.Desc.Name())), ",")
+		generated[value.Desc.Number()] = true
+	}
+	g.P("}")
+	g.P()
+	valueMap := enum.GoIdent.GoName + "_value"
+	g.P("var ", valueMap, " = map[string]int32{")
+	for _, value := range enum.Values {
+		g.P(strconv.Quote(string(value.Desc.Name())), ": ", value.Desc.Number(), ",")
+	}
+	g.P("}")
+	g.P()
+	if enum.Desc.Syntax() != protoreflect.Proto3 {
+		g.P("func (x ", enum.GoIdent, ") Enum() *", enum.GoIdent, " {")
+		g.P("p := new(", enum.GoIdent, ")")
+		g.P("*p = x")
+		g.P("return p")
+		g.P("}")
+		g.P()
+	}
+	g.P("func (x ", enum.GoIdent, ") String() string {")
+	g.P("return ", protogen.GoIdent{GoImportPath: ProtoPackage, GoName: "EnumName"}, "(", enum.GoIdent, "_name, int32(x))")
+	g.P("}")
+	g.P()
+
+	if enum.Desc.Syntax() != protoreflect.Proto3 {
+		g.P("func (x *", enum.GoIdent, ") UnmarshalJSON(data []byte) error {")
+		g.P("value, err := ", protogen.GoIdent{GoImportPath: ProtoPackage, GoName: "UnmarshalJSONEnum"}, "(", enum.GoIdent, `_value, data, "`, enum.GoIdent, `")`)
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("*x = ", enum.GoIdent, "(value)")
+		g.P("return nil")
+		g.P("}")
+		g.P()
+	}
+
+	var indexes []string
+	for i := 1; i < len(enum.Path); i += 2 {
+		indexes = append(indexes, strconv.Itoa(int(enum.Path[i])))
+	}
+	g.P("func (", enum.GoIdent, ") EnumDescriptor() ([]byte, []int) {")
+	g.P("return ", f.DescriptorVar, ", []int{", strings.Join(indexes, ","), "}")
+	g.P("}")
+	g.P()
+
+	genWellKnownType(g, enum.GoIdent, enum.Desc)
+
+	// The name registered is, confusingly, <proto_package>.<go_ident>.
+	// This probably should have been the full name of the proto enum
+	// type instead, but changing it at this point would require thought.
+	regName := string(f.Desc.Package()) + "." + enum.GoIdent.GoName
+	f.AppendInit(fmt.Sprintf("%s(%q, %s, %s)",
+		g.QualifiedGoIdent(protogen.GoIdent{
+			GoImportPath: ProtoPackage,
+			GoName:       "RegisterEnum",
+		}),
+		regName, nameMap, valueMap,
+	))
+
+	if f.Hooks.Enum != nil {
+		f.Hooks.Enum(g, f, enum)
+	}
+}
+
+func genMessage(g *protogen.GeneratedFile, f *File, message *protogen.Message) {
+	for _, enum := range message.Enums {
+		genEnum(g, f, enum)
+	}
+
+	GenComment(g, f, message.Path)
+	g.P("type ", message.GoIdent, " struct {")
+	g.P("}")
+	g.P()
+
+	for _, nested := range message.Messages {
+		genMessage(g, f, nested)
+	}
+
+	if f.Hooks.Message != nil {
+		f.Hooks.Message(g, f, message)
+	}
+}
+
+func genService(g *protogen.GeneratedFile, f *File, service *protogen.Service) {
+	GenComment(g, f, service.Path)
+	clientName := service.GoName + "Client"
+	g.P("type ", clientName, " interface {")
+	for _, method := range service.Methods {
+		GenComment(g, f, method.Path)
+		g.P(method.GoName, "(ctx ", ContextIdent, ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error)")
+	}
+	g.P("}")
+	g.P()
+
+	serverName := service.GoName + "Server"
+	g.P("type ", serverName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.GoName, "(ctx ", ContextIdent, ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error)")
+	}
+	g.P("}")
+	g.P()
+
+	if f.Hooks.Service != nil {
+		f.Hooks.Service(g, f, service)
+	}
+}
+
+func genWellKnownType(g *protogen.GeneratedFile, ident protogen.GoIdent, desc protoreflect.Descriptor) {
+	if wellKnownTypes[desc.FullName()] {
+		g.P("func (", ident, `) XXX_WellKnownType() string { return "`, desc.Name(), `" }`)
+		g.P()
+	}
+}
+
+// Names of messages and enums for which we will generate XXX_WellKnownType methods.
+var wellKnownTypes = map[protoreflect.FullName]bool{
+	"google.protobuf.NullValue": true,
+}